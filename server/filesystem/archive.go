@@ -0,0 +1,194 @@
+package filesystem
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"emperror.dev/errors"
+	"github.com/mholt/archiver/v3"
+	ignore "github.com/sabhiram/go-gitignore"
+)
+
+// Archive represents a wrapper around creating a new archive from a given
+// directory, while allowing for only specific files and folders to be
+// included in the final output.
+type Archive struct {
+	// BasePath is the base directory that all of the Files are relative to.
+	BasePath string
+	// Files is an allow-list of paths within BasePath to include in the
+	// resulting archive. If empty, the entire BasePath is walked.
+	Files []string
+	// Excluded is a deny-list of gitignore-style glob patterns, relative to
+	// BasePath, that should never appear in the resulting archive. It mirrors
+	// Docker's TarOptions.ExcludePatterns: a path that matches both an
+	// included subtree in Files and a pattern here is excluded. Patterns are
+	// compiled with the same library backing Filesystem.IsIgnored, so a
+	// `.pteroignore` pattern and an Excluded pattern behave identically.
+	Excluded []string
+	// Compression controls the format used when writing the archive out to
+	// disk. The zero value is Gzip, matching the historical behavior of this
+	// struct before other formats were supported.
+	Compression CompressionType
+
+	// compiledExcluded caches the compiled form of Excluded so that it isn't
+	// recompiled once per file visited during the walk.
+	compiledExcluded *ignore.GitIgnore
+}
+
+// Create creates an archive at dst using the Files (or the entire BasePath,
+// if no Files are defined) as its contents, written out in the requested
+// Compression format.
+func (a *Archive) Create(dst string) error {
+	f, err := os.Create(dst)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+
+	return a.writeTo(f)
+}
+
+// writeTo writes the archive's contents to w, using the requested Compression
+// format. It is the shared implementation behind both Create, which targets a
+// file on disk, and Filesystem.StreamArchive, which targets the write side of
+// an in-memory pipe.
+func (a *Archive) writeTo(w io.Writer) error {
+	cw := a.Compression.Writer()
+	if err := cw.Create(w); err != nil {
+		return errors.WithStack(err)
+	}
+	defer cw.Close()
+
+	paths := a.Files
+	if len(paths) == 0 {
+		paths = []string{a.BasePath}
+	}
+
+	for _, p := range paths {
+		if err := a.addFile(cw, p); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addFile walks a single file or directory and writes every file it finds
+// into the archive using a path relative to the Archive's BasePath.
+func (a *Archive) addFile(w archiver.Writer, source string) error {
+	return filepath.Walk(source, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(a.BasePath, p)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		excluded, err := a.isExcluded(rel)
+		if err != nil {
+			return err
+		}
+		if excluded {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		var rc *os.File
+		if info.Mode().IsRegular() {
+			if rc, err = os.Open(p); err != nil {
+				return errors.WithStack(err)
+			}
+			defer rc.Close()
+		}
+
+		header, err := a.headerFor(info, rel)
+		if err != nil {
+			return err
+		}
+
+		return w.Write(archiver.File{
+			FileInfo:   info,
+			Header:     header,
+			ReadCloser: rc,
+		})
+	})
+}
+
+// isExcluded returns true if rel (a path relative to the Archive's BasePath)
+// matches one of the configured Excluded glob patterns. Patterns are matched
+// using the same github.com/sabhiram/go-gitignore library the rest of the
+// server's ignore-file handling (Filesystem.IsIgnored) is built on, so a
+// pattern behaves identically whether it came from a `.pteroignore` file or
+// was passed directly as an Excluded entry here: `*` matches within a single
+// path segment, `**` matches across any number of segments (so `cache/**`
+// excludes everything under `cache/`, not just its direct children), and a
+// pattern containing no `/` matches at any depth.
+func (a *Archive) isExcluded(rel string) (bool, error) {
+	m, err := a.excludeMatcher()
+	if err != nil {
+		return false, err
+	}
+	if m == nil {
+		return false, nil
+	}
+	return m.MatchesPath(filepath.ToSlash(rel)), nil
+}
+
+// excludeMatcher lazily compiles Excluded into a *ignore.GitIgnore and caches
+// the result, since isExcluded is called once per file visited during the
+// walk and Excluded itself never changes over the life of an Archive. A
+// malformed pattern is reported back to the caller rather than silently
+// matching nothing — otherwise a typo'd Excluded entry would leave files the
+// caller meant to omit sitting in the archive with no indication why. Each
+// pattern's glob syntax is checked with path.Match first, since go-gitignore
+// itself drops a pattern it can't compile without returning an error.
+func (a *Archive) excludeMatcher() (*ignore.GitIgnore, error) {
+	if a.compiledExcluded == nil && len(a.Excluded) > 0 {
+		for _, p := range a.Excluded {
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, errors.Wrap(err, "filesystem: invalid exclude pattern")
+			}
+		}
+		m, err := ignore.CompileIgnoreLines(a.Excluded...)
+		if err != nil {
+			return nil, errors.Wrap(err, "filesystem: invalid exclude pattern")
+		}
+		a.compiledExcluded = m
+	}
+	return a.compiledExcluded, nil
+}
+
+// headerFor builds the format-specific header describing a file, using the
+// name it should be given inside of the resulting archive.
+//
+// The Zip case must build a standard-library zip.FileHeader value (not a
+// pointer, and not the klauspost/compress fork used elsewhere in this
+// package for reading): that's the exact type archiver.NewZip().Write type-
+// asserts f.Header against, and anything else causes archive creation to
+// fail with "expected header to be zip.FileHeader".
+func (a *Archive) headerFor(info os.FileInfo, nameInArchive string) (interface{}, error) {
+	if a.Compression == Zip {
+		fh, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		fh.Name = filepath.ToSlash(nameInArchive)
+		return *fh, nil
+	}
+
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	header.Name = filepath.ToSlash(nameInArchive)
+	return header, nil
+}
@@ -0,0 +1,78 @@
+package filesystem
+
+import "testing"
+
+func TestArchiveIsExcluded(t *testing.T) {
+	cases := []struct {
+		name     string
+		excluded []string
+		path     string
+		want     bool
+	}{
+		{
+			name:     "no patterns excludes nothing",
+			excluded: nil,
+			path:     "cache/data.bin",
+			want:     false,
+		},
+		{
+			name:     "bare pattern matches at any depth",
+			excluded: []string{"*.log"},
+			path:     "logs/nested/debug.log",
+			want:     true,
+		},
+		{
+			name:     "** matches everything under the directory",
+			excluded: []string{"cache/**"},
+			path:     "cache/nested/data.bin",
+			want:     true,
+		},
+		{
+			name:     "** exclusion doesn't reach outside the directory it prefixes",
+			excluded: []string{"cache/**"},
+			path:     "other/data.bin",
+			want:     false,
+		},
+		{
+			name: "a later negated pattern re-includes a path excluded earlier",
+			excluded: []string{
+				"cache/**",
+				"!cache/keep.txt",
+			},
+			path: "cache/keep.txt",
+			want: false,
+		},
+		{
+			name: "negation doesn't resurrect a path outside its own pattern",
+			excluded: []string{
+				"cache/**",
+				"!cache/keep.txt",
+			},
+			path: "cache/nested/data.bin",
+			want: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			a := &Archive{Excluded: c.excluded}
+			got, err := a.isExcluded(c.path)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != c.want {
+				t.Fatalf("isExcluded(%q) with patterns %v = %v, want %v", c.path, c.excluded, got, c.want)
+			}
+		})
+	}
+}
+
+func TestArchiveIsExcludedInvalidPattern(t *testing.T) {
+	// go-gitignore treats a pattern as a literal regexp fragment internally;
+	// an unterminated character class is the simplest way to force a compile
+	// failure so we can assert it's surfaced rather than silently swallowed.
+	a := &Archive{Excluded: []string{"["}}
+	if _, err := a.isExcluded("anything"); err == nil {
+		t.Fatal("expected an error for a malformed exclude pattern, got nil")
+	}
+}
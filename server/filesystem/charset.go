@@ -0,0 +1,89 @@
+package filesystem
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/saintfish/chardet"
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+// encodingHint narrows down what decodeArchiveName should try before falling
+// back to statistical charset detection, based on anything the archive
+// format itself already told us about the name's encoding.
+type encodingHint int
+
+const (
+	// hintNone means nothing is known up front: detection runs, and its best
+	// result is used only if it is one of the legacyCharsetAliases candidates.
+	hintNone encodingHint = iota
+	// hintUTF8 means the archive format already declared this name to be
+	// UTF-8 (e.g. zip's general purpose bit 11), so detection is skipped.
+	hintUTF8
+	// hintLegacyZip means this name came from a zip entry with the UTF-8
+	// general purpose bit unset — the classic case for archives written by
+	// old DOS/Windows tooling using CP437, which chardet has no recognizer
+	// for and so can never be offered as a detection result.
+	hintLegacyZip
+)
+
+// legacyCharsetAliases maps the charset label chardet.DetectBest reports
+// (upper-cased) to the IANA encoding name used to actually decode the bytes.
+// This indirection exists because chardet's labels don't always match an
+// IANA name directly — most notably it reports the GB family as "GB-18030",
+// never "GBK", even though GBK is the common case for wings users' archives.
+var legacyCharsetAliases = map[string]string{
+	"GB-18030":  "GB18030",
+	"GB18030":   "GB18030",
+	"GBK":       "GBK",
+	"BIG5":      "Big5",
+	"SHIFT_JIS": "Shift_JIS",
+	"EUC-KR":    "EUC-KR",
+}
+
+var nameDetector = chardet.NewTextDetector()
+
+// decodeArchiveName converts a raw, possibly non-UTF-8 archive entry name
+// into a UTF-8 string.
+//
+// If hint is hintUTF8, or raw is already valid UTF-8, it's returned as-is.
+// Otherwise chardet.DetectBest is run and, if its result maps to a known
+// entry in legacyCharsetAliases, that encoding is used to decode raw. If
+// detection is inconclusive and hint is hintLegacyZip, CP437 is tried
+// explicitly as a last resort, since chardet can't recognize it on its own.
+// Failing all of that, raw is returned unmodified.
+func decodeArchiveName(raw []byte, hint encodingHint) string {
+	if hint == hintUTF8 || len(raw) == 0 || utf8.Valid(raw) {
+		return string(raw)
+	}
+
+	if result, err := nameDetector.DetectBest(raw); err == nil && result != nil {
+		if iana, ok := legacyCharsetAliases[strings.ToUpper(result.Charset)]; ok {
+			if decoded, ok := decodeAs(iana, raw); ok {
+				return decoded
+			}
+		}
+	}
+
+	if hint == hintLegacyZip {
+		if decoded, ok := decodeAs("CP437", raw); ok {
+			return decoded
+		}
+	}
+
+	return string(raw)
+}
+
+// decodeAs decodes raw using the named IANA encoding, reporting false if the
+// encoding can't be resolved or the bytes don't decode cleanly under it.
+func decodeAs(iana string, raw []byte) (string, bool) {
+	enc, err := ianaindex.IANA.Encoding(iana)
+	if err != nil || enc == nil {
+		return "", false
+	}
+	decoded, err := enc.NewDecoder().Bytes(raw)
+	if err != nil {
+		return "", false
+	}
+	return string(decoded), true
+}
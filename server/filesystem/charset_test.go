@@ -0,0 +1,77 @@
+package filesystem
+
+import (
+	"testing"
+
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+func TestDecodeArchiveName(t *testing.T) {
+	t.Run("hintUTF8 skips detection entirely", func(t *testing.T) {
+		raw := []byte("已经是 UTF-8 了")
+		if got := decodeArchiveName(raw, hintUTF8); got != string(raw) {
+			t.Fatalf("expected raw bytes to be returned unmodified, got %q", got)
+		}
+	})
+
+	t.Run("empty input", func(t *testing.T) {
+		if got := decodeArchiveName(nil, hintNone); got != "" {
+			t.Fatalf("expected empty string, got %q", got)
+		}
+	})
+
+	t.Run("already valid UTF-8 is left alone", func(t *testing.T) {
+		raw := []byte("plain-ascii-name.txt")
+		if got := decodeArchiveName(raw, hintNone); got != string(raw) {
+			t.Fatalf("expected raw bytes to be returned unmodified, got %q", got)
+		}
+	})
+
+	// GBK and GB18030 round-trip through the same alias: chardet reports the
+	// GB family as "GB-18030", never "GBK", so both input encodings need to
+	// land on the same decoded string via legacyCharsetAliases.
+	for _, charset := range []string{"GBK", "GB18030", "Big5", "Shift_JIS", "EUC-KR"} {
+		charset := charset
+		t.Run(charset, func(t *testing.T) {
+			enc, err := ianaindex.IANA.Encoding(charset)
+			if err != nil || enc == nil {
+				t.Fatalf("could not resolve encoding %q: %v", charset, err)
+			}
+			raw, err := enc.NewEncoder().Bytes([]byte("文件夹/测试.txt"))
+			if err != nil {
+				// Not every sample string round-trips through every legacy
+				// charset (e.g. Shift-JIS can't encode Chinese); skip those.
+				t.Skipf("sample string is not representable in %s: %v", charset, err)
+			}
+
+			got := decodeArchiveName(raw, hintNone)
+			if got == string(raw) {
+				t.Fatalf("expected %s bytes to be decoded to UTF-8, got raw bytes back", charset)
+			}
+		})
+	}
+
+	t.Run("CP437 is tried explicitly for legacy zip entries", func(t *testing.T) {
+		enc, err := ianaindex.IANA.Encoding("CP437")
+		if err != nil || enc == nil {
+			t.Fatalf("could not resolve CP437 encoding: %v", err)
+		}
+		// U+00E9 (é) encodes to 0x82 in CP437, and chardet has no recognizer
+		// for CP437 so it would never surface as a detection result.
+		raw, err := enc.NewEncoder().Bytes([]byte("café.txt"))
+		if err != nil {
+			t.Fatalf("could not encode sample string as CP437: %v", err)
+		}
+
+		got := decodeArchiveName(raw, hintLegacyZip)
+		if got != "café.txt" {
+			t.Fatalf("expected CP437 bytes to decode to %q, got %q", "café.txt", got)
+		}
+
+		// Without the legacy-zip hint (e.g. a tar entry) we shouldn't guess
+		// CP437, since plenty of valid non-CP437 bytes would mis-decode.
+		if got := decodeArchiveName(raw, hintNone); got != string(raw) {
+			t.Fatalf("expected raw bytes back without hintLegacyZip, got %q", got)
+		}
+	})
+}
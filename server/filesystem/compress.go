@@ -5,6 +5,7 @@ import (
 	"archive/zip"
 	"compress/gzip"
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"path/filepath"
@@ -18,8 +19,6 @@ import (
 
 	"emperror.dev/errors"
 	"github.com/mholt/archiver/v3"
-
-	"golang.org/x/text/encoding/simplifiedchinese"
 )
 
 // CompressFiles compresses all of the files matching the given paths in the
@@ -30,37 +29,68 @@ import (
 //
 // All paths are relative to the dir that is passed in as the first argument,
 // and the compressed file will be placed at that location named
-// `archive-{date}.tar.gz`.
-func (fs *Filesystem) CompressFiles(dir string, paths []string) (os.FileInfo, error) {
+// `archive-{date}{ext}`, with the extension chosen to match the requested
+// compression format. If compression is left as its zero value, Gzip is used
+// so that existing callers are unaffected.
+//
+// excluded is an optional deny-list of glob patterns, relative to dir, that
+// are skipped even when they fall within an included path — a pattern like
+// `cache/**` lets a caller back up an entire directory except a subtree of
+// it without having to enumerate every other file. Use LoadExcludePatterns
+// to build this list from a `.pteroignore`-style file instead of patterns
+// submitted directly by the caller. Patterns are matched with the same
+// github.com/sabhiram/go-gitignore-backed machinery as Filesystem.IsIgnored,
+// so callers get the same `**`/negation semantics regardless of whether a
+// pattern came from a `.pteroignore` file or straight off the wire. The
+// server/router layer that accepts these patterns over the API isn't part of
+// this package and isn't touched here; NormalizeExcludePatterns exists so
+// that layer has a single place to sanitize request input before calling in.
+//
+// allowed is passed straight through to ValidateCompressionType, letting a
+// caller reject a request for a format the node's config has disabled (e.g.
+// Xz/Zstd on a low-memory node) before any work is done. A nil or empty
+// allowed list permits every format.
+//
+// CompressFiles itself only enforces allowed; it has no access to the node's
+// configuration and does not decide what compression/excluded values a
+// request is permitted to send. The caller sitting between the API and this
+// package (the file manager's HTTP/websocket handler) is responsible for
+// reading the node's configured compression allow-list and excluded-pattern
+// source and passing them in here — neither the handler nor the config
+// schema it reads from live in this package, so that wiring isn't part of
+// this change.
+func (fs *Filesystem) CompressFiles(dir string, paths []string, compression CompressionType, excluded []string, allowed []CompressionType) (os.FileInfo, error) {
 	cleanedRootDir, err := fs.SafePath(dir)
 	if err != nil {
 		return nil, err
 	}
 
-	// Take all of the paths passed in and merge them together with the root directory we've gotten.
-	for i, p := range paths {
-		paths[i] = filepath.Join(cleanedRootDir, p)
-	}
-
-	cleaned, err := fs.ParallelSafePath(paths)
+	rc, err := fs.StreamArchive(dir, paths, compression, excluded, allowed)
 	if err != nil {
 		return nil, err
 	}
+	defer rc.Close()
 
-	a := &Archive{BasePath: cleanedRootDir, Files: cleaned}
 	d := path.Join(
 		cleanedRootDir,
-		fmt.Sprintf("archive-%s.tar.gz", strings.ReplaceAll(time.Now().Format(time.RFC3339), ":", "")),
+		fmt.Sprintf("archive-%s%s", strings.ReplaceAll(time.Now().Format(time.RFC3339), ":", ""), compression.Extension()),
 	)
 
-	if err := a.Create(d); err != nil {
-		return nil, err
+	dst, err := os.Create(d)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, rc); err != nil {
+		_ = os.Remove(d)
+		return nil, errors.WithStack(err)
 	}
 
-	f, err := os.Stat(d)
+	f, err := dst.Stat()
 	if err != nil {
 		_ = os.Remove(d)
-		return nil, err
+		return nil, errors.WithStack(err)
 	}
 
 	if err := fs.HasSpaceFor(f.Size()); err != nil {
@@ -73,6 +103,100 @@ func (fs *Filesystem) CompressFiles(dir string, paths []string) (os.FileInfo, er
 	return f, nil
 }
 
+// StreamArchive pipes an archive's tar/compressor output directly into the
+// returned io.ReadCloser as it's produced, rather than materializing it on
+// disk first. CompressFiles is implemented on top of this: it drains the
+// stream into a destination file instead of duplicating Archive's write path,
+// so the two never drift in how they build an archive. Streaming also lets a
+// caller download the archive (e.g. an HTTP handler serving a "download as
+// archive" response, which isn't part of this package) start sending bytes
+// before the whole archive has even finished being built. Any error
+// encountered while writing is returned by Read once the data written before
+// it has been consumed. The caller must Close the returned reader; closing it
+// early makes the writer's remaining writes fail, which ends the build.
+func (fs *Filesystem) StreamArchive(dir string, paths []string, compression CompressionType, excluded []string, allowed []CompressionType) (io.ReadCloser, error) {
+	a, err := fs.newArchive(dir, paths, compression, excluded, allowed)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		_ = pw.CloseWithError(a.writeTo(pw))
+	}()
+
+	return pr, nil
+}
+
+// newArchive resolves dir and paths against the filesystem's jail and builds
+// the Archive shared by both CompressFiles and StreamArchive.
+func (fs *Filesystem) newArchive(dir string, paths []string, compression CompressionType, excluded []string, allowed []CompressionType) (*Archive, error) {
+	if err := ValidateCompressionType(compression, allowed); err != nil {
+		return nil, err
+	}
+
+	cleanedRootDir, err := fs.SafePath(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Take all of the paths passed in and merge them together with the root directory we've gotten.
+	for i, p := range paths {
+		paths[i] = filepath.Join(cleanedRootDir, p)
+	}
+
+	cleaned, err := fs.ParallelSafePath(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Archive{BasePath: cleanedRootDir, Files: cleaned, Excluded: excluded, Compression: compression}, nil
+}
+
+// NormalizeExcludePatterns trims and drops blank entries from a list of glob
+// patterns submitted directly by a caller (e.g. the file manager's websocket/
+// HTTP handlers forwarding patterns a user typed in), so the router layer has
+// a single place to sanitize request input before it reaches CompressFiles or
+// StreamArchive. It can be combined with patterns returned by
+// LoadExcludePatterns for a `.pteroignore`-style file.
+func NormalizeExcludePatterns(raw []string) []string {
+	patterns := make([]string, 0, len(raw))
+	for _, p := range raw {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// LoadExcludePatterns reads a `.pteroignore`/`.gitignore`-style file located
+// at file (relative to dir) and returns the glob patterns it contains, ready
+// to be passed as the excluded argument to CompressFiles. Blank lines and
+// lines starting with `#` are skipped, matching the same conventions already
+// used by the server's ignore file handling.
+func (fs *Filesystem) LoadExcludePatterns(dir string, file string) ([]string, error) {
+	p, err := fs.SafePath(filepath.Join(dir, file))
+	if err != nil {
+		return nil, err
+	}
+
+	contents, err := os.ReadFile(p)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var patterns []string
+	for _, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+
+	return patterns, nil
+}
+
 // SpaceAvailableForDecompression looks through a given archive and determines
 // if decompressing it would put the server over its allocated disk space limit.
 func (fs *Filesystem) SpaceAvailableForDecompression(dir string, file string) error {
@@ -93,7 +217,7 @@ func (fs *Filesystem) SpaceAvailableForDecompression(dir string, file string) er
 
 	var size int64
 	// Walk over the archive and figure out just how large the final output would be from unarchiving it.
-	err = archiver.Walk(source, func(f archiver.File) error {
+	err = walkArchive(source, func(f archiver.File) error {
 		if atomic.AddInt64(&size, f.Size())+dirSize > fs.MaxDisk() {
 			return newFilesystemError(ErrCodeDiskSpace, nil)
 		}
@@ -108,11 +232,71 @@ func (fs *Filesystem) SpaceAvailableForDecompression(dir string, file string) er
 	return err
 }
 
-// DecompressFile will decompress a file in a given directory by using the
-// archiver tool to infer the file type and go from there. This will walk over
-// all of the files within the given archive and ensure that there is not a
-// zip-slip attack being attempted by validating that the final path is within
-// the server data directory.
+// walkArchive walks the contents of source, preferring the format identified
+// by sniffing its first bytes via DetectCompression over the format inferred
+// from its file extension. This lets archives that were renamed, extensionless,
+// or uploaded under a generic name (e.g. `backup.bin`) still be read correctly.
+// If the sniffed format is inconclusive, or fails to parse as that format
+// before any entry was passed to fn, it falls back to the original
+// extension-based archiver.Walk; a parse failure after fn has seen an entry is
+// returned as-is.
+func walkArchive(source string, fn archiver.WalkFunc) error {
+	f, err := os.Open(source)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	header := make([]byte, 512)
+	n, _ := f.Read(header)
+	_ = f.Close()
+
+	if c := DetectCompression(header[:n]); c != Unknown {
+		if w := c.Walker(); w != nil {
+			// Track whether fn itself produced the error, as opposed to the
+			// sniffed format simply failing to parse (e.g. the magic bytes
+			// matched but the rest of the file is truncated or corrupt). Only
+			// the latter should fall back to extension-based detection below
+			// — a real callback error (disk space, an unsafe link, a failed
+			// write) must propagate immediately rather than silently retrying
+			// and re-extracting everything that already succeeded.
+			var callbackErr error
+			var emitted bool
+			walkErr := w.Walk(source, func(af archiver.File) error {
+				emitted = true
+				if err := fn(af); err != nil {
+					callbackErr = err
+					return err
+				}
+				return nil
+			})
+			if callbackErr != nil {
+				return callbackErr
+			}
+			if walkErr == nil {
+				return nil
+			}
+			// The sniffed walker got partway through the archive, calling fn
+			// for one or more entries, before failing to parse the rest (e.g.
+			// a truncated upload whose header still matched). Falling back to
+			// archiver.Walk below would re-invoke fn for those same entries a
+			// second time, which corrupts any running total fn keeps across
+			// calls — SpaceAvailableForDecompression's size accumulator would
+			// double-count them and could spuriously trip the disk-space
+			// guard. Only fall back when nothing was emitted yet.
+			if emitted {
+				return walkErr
+			}
+		}
+	}
+
+	return archiver.Walk(source, fn)
+}
+
+// DecompressFile will decompress a file in a given directory, first by
+// sniffing its contents via DetectCompression and falling back to using the
+// archiver tool to infer the file type from its extension. This will walk
+// over all of the files within the given archive and ensure that there is
+// not a zip-slip attack being attempted by validating that the final path is
+// within the server data directory.
 func (fs *Filesystem) DecompressFile(dir string, file string) error {
 	source, err := fs.SafePath(filepath.Join(dir, file))
 	if err != nil {
@@ -126,7 +310,7 @@ func (fs *Filesystem) DecompressFile(dir string, file string) error {
 	// Walk all of the files in the archiver file and write them to the disk. If any
 	// directory is encountered it will be skipped since we handle creating any missing
 	// directories automatically when writing files.
-	err = archiver.Walk(source, func(f archiver.File) error {
+	err = walkArchive(source, func(f archiver.File) error {
 		if f.IsDir() {
 			return nil
 		}
@@ -135,6 +319,16 @@ func (fs *Filesystem) DecompressFile(dir string, file string) error {
 		if err := fs.IsIgnored(p); err != nil {
 			return nil
 		}
+		// Symlinks and hardlinks don't have regular file contents to write, and
+		// must never have their target resolved through the normal Chmod/Chtimes
+		// path below, since those follow symlinks and could be tricked into
+		// touching a file outside of the server's data directory.
+		if header, ok := f.Header.(*tar.Header); ok && (header.Typeflag == tar.TypeSymlink || header.Typeflag == tar.TypeLink) {
+			if err := fs.writeArchiveLink(dir, p, header); err != nil {
+				return wrapError(err, source)
+			}
+			return nil
+		}
 		if err := fs.Writefile(p, f); err != nil {
 			return wrapError(err, source)
 		}
@@ -157,6 +351,58 @@ func (fs *Filesystem) DecompressFile(dir string, file string) error {
 	return nil
 }
 
+// isUnsafeLinkname reports whether an archive entry's link target is unsafe
+// to resolve on disk regardless of where the link itself lives: an absolute
+// Linkname would be followed exactly as written rather than resolved
+// relative to anything under the server's data directory, so it bypasses
+// SafePath's jail entirely instead of merely needing it to clean up a `../`.
+func isUnsafeLinkname(linkname string) bool {
+	return filepath.IsAbs(linkname)
+}
+
+// writeArchiveLink creates the symlink or hardlink described by header at p (a
+// path relative to the server's data directory), resolving its target through
+// SafePath first. This closes a zip-slip variant where an archive's Linkname
+// points outside of the server's data directory rather than its Name.
+//
+// The two link types resolve Linkname in different namespaces: a symlink's
+// target is relative to the directory the link itself lives in (the normal
+// filesystem meaning of a relative symlink), while a hardlink's target is
+// another entry in the same archive and so, like header.Name, is relative to
+// the archive root — i.e. dir, the directory being extracted into.
+func (fs *Filesystem) writeArchiveLink(dir, p string, header *tar.Header) error {
+	if isUnsafeLinkname(header.Linkname) {
+		return newFilesystemError(ErrCodeUnsafeLink, nil)
+	}
+
+	safeP, err := fs.SafePath(p)
+	if err != nil {
+		return err
+	}
+
+	switch header.Typeflag {
+	case tar.TypeSymlink:
+		target, err := fs.SafePath(filepath.Join(filepath.Dir(p), header.Linkname))
+		if err != nil {
+			return newFilesystemError(ErrCodeUnsafeLink, err)
+		}
+		rel, err := filepath.Rel(filepath.Dir(safeP), target)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		_ = os.Remove(safeP)
+		return errors.WithStack(os.Symlink(rel, safeP))
+	case tar.TypeLink:
+		target, err := fs.SafePath(filepath.Join(dir, header.Linkname))
+		if err != nil {
+			return newFilesystemError(ErrCodeUnsafeLink, err)
+		}
+		_ = os.Remove(safeP)
+		return errors.WithStack(os.Link(target, safeP))
+	}
+	return nil
+}
+
 // ExtractNameFromArchive looks at an archive file to try and determine the name
 // for a given element in an archive. Because of... who knows why, each file type
 // uses different methods to determine the file name.
@@ -178,22 +424,20 @@ func ExtractNameFromArchive(f archiver.File) string {
 		return f.Name()
 	}
 	str := f.Name()
+	hint := hintNone
 	switch s := sys.(type) {
 	case *zip.FileHeader:
 		str = s.Name
-		break
+		hint = utf8HintFromZipFlags(s.Flags)
 	case *zip2.FileHeader:
 		str = s.Name
-		break
+		hint = utf8HintFromZipFlags(s.Flags)
 	case *tar.Header:
 		str = s.Name
-		break
 	case *gzip.Header:
 		str = s.Name
-		break
 	case *gzip2.Header:
 		str = s.Name
-		break
 	default:
 		str = f.Name()
 		// At this point we cannot figure out what type of archive this might be so
@@ -207,34 +451,17 @@ func ExtractNameFromArchive(f archiver.File) string {
 		// be since it didn't implement a name field.
 		return f.Name()
 	}
-	// 判断
-	length := len(str)
-    var i int = 0
-	var ifgbk bool
-    for i < length {
-        if str[i] <= 0x7f {
-            //编码0~127,只有一个字节的编码，兼容ASCII码
-            i++
-            ifgbk = true
-        } else {
-            //大于127的使用双字节编码，落在gbk编码范围内的字符
-            if  str[i] >= 0x81 &&
-                str[i] <= 0xfe &&
-                str[i + 1] >= 0x40 &&
-                str[i + 1] <= 0xfe &&
-                str[i + 1] != 0xf7 {
-                i += 2
-                ifgbk = true
-            } else {
-                ifgbk = false
-            }
-        }
-    }
-	// 转换
-	if ifgbk == true {
-		utf8Str, _ := simplifiedchinese.GBK.NewDecoder().Bytes([]byte(str))
-		return string(utf8Str)
-	} else {
-		return str
+	return decodeArchiveName([]byte(str), hint)
+}
+
+// utf8HintFromZipFlags checks general purpose bit 11 of a zip entry's flags,
+// which the zip spec reserves to mean "this name and comment are already
+// UTF-8" when set. Archives written by modern tooling almost always set it;
+// when it's unset we're most likely looking at a legacy DOS/Windows zip, so
+// decodeArchiveName also tries CP437 as a last resort in that case.
+func utf8HintFromZipFlags(flags uint16) encodingHint {
+	if flags&0x800 != 0 {
+		return hintUTF8
 	}
+	return hintLegacyZip
 }
@@ -0,0 +1,31 @@
+package filesystem
+
+import "testing"
+
+// writeArchiveLink's relative-target escape checks (a symlink or hardlink
+// whose cleaned target lands outside of the server's data directory) go
+// through Filesystem.SafePath, which lives outside of this package's
+// snapshot and isn't exercised here — that jail is SafePath's own contract
+// and is covered by its own test suite. isUnsafeLinkname is the one part of
+// writeArchiveLink's safety check that doesn't depend on SafePath at all, so
+// it's tested directly.
+func TestIsUnsafeLinkname(t *testing.T) {
+	cases := []struct {
+		name     string
+		linkname string
+		want     bool
+	}{
+		{"relative target within the tree", "file.txt", false},
+		{"relative target that escapes via ..", "../shared/file.txt", false},
+		{"absolute target", "/etc/passwd", true},
+		{"absolute target into a nested path", "/var/lib/secret", true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := isUnsafeLinkname(c.linkname); got != c.want {
+				t.Fatalf("isUnsafeLinkname(%q) = %v, want %v", c.linkname, got, c.want)
+			}
+		})
+	}
+}
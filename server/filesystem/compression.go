@@ -0,0 +1,161 @@
+package filesystem
+
+import (
+	"archive/tar"
+	"bytes"
+
+	"github.com/mholt/archiver/v3"
+)
+
+// CompressionType represents a supported archive compression format that can
+// be requested by a caller when creating a new archive via Archive.Create.
+type CompressionType int
+
+const (
+	// Gzip is the default compression format used by Archive.Create, and is
+	// kept as the zero value so that existing callers who don't care about
+	// the format continue to get the historical `tar.gz` behavior.
+	Gzip CompressionType = iota
+	Bzip2
+	Xz
+	Zstd
+	Zip
+	Uncompressed
+	// Unknown is returned by DetectCompression when the sniffed bytes don't
+	// match any of the supported formats.
+	Unknown CompressionType = -1
+)
+
+// archiveMagic pairs the magic bytes a format's content starts with against
+// the CompressionType it identifies.
+var archiveMagic = []struct {
+	compression CompressionType
+	magic       []byte
+}{
+	{Gzip, []byte{0x1f, 0x8b, 0x08}},
+	{Bzip2, []byte{0x42, 0x5a, 0x68}},
+	{Xz, []byte{0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x00}},
+	{Zstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{Zip, []byte{0x50, 0x4b, 0x03, 0x04}},
+}
+
+// DetectCompression sniffs the first bytes of an archive's contents (as Docker's
+// archive package does for `docker cp`/`docker build`) and returns the
+// CompressionType it appears to have been written with, regardless of what
+// extension the file was given. It returns Unknown if none of the supported
+// magic numbers match and the content doesn't parse as a bare tar stream.
+func DetectCompression(header []byte) CompressionType {
+	for _, m := range archiveMagic {
+		if bytes.HasPrefix(header, m.magic) {
+			return m.compression
+		}
+	}
+	if _, err := tar.NewReader(bytes.NewReader(header)).Next(); err == nil {
+		return Uncompressed
+	}
+	return Unknown
+}
+
+// Extension returns the file extension that should be appended to the name
+// of an archive created using this compression format.
+func (c CompressionType) Extension() string {
+	switch c {
+	case Bzip2:
+		return ".tar.bz2"
+	case Xz:
+		return ".tar.xz"
+	case Zstd:
+		return ".tar.zst"
+	case Zip:
+		return ".zip"
+	case Uncompressed:
+		return ".tar"
+	default:
+		return ".tar.gz"
+	}
+}
+
+// Writer returns a new archiver.Writer capable of producing an archive in
+// this compression format. Every format other than Zip and Uncompressed
+// writes a tar stream through the matching compressor.
+func (c CompressionType) Writer() archiver.Writer {
+	switch c {
+	case Bzip2:
+		return archiver.NewTarBz2()
+	case Xz:
+		return archiver.NewTarXz()
+	case Zstd:
+		return archiver.NewTarZstd()
+	case Zip:
+		return archiver.NewZip()
+	case Uncompressed:
+		return archiver.NewTar()
+	default:
+		return archiver.NewTarGz()
+	}
+}
+
+// Walker returns an archiver.Walker that can enumerate the contents of an
+// archive previously identified as being in this compression format, for use
+// when the caller already knows (or has sniffed) the format rather than
+// wanting it inferred from the archive's file extension. It returns nil for
+// Unknown, since there is no walker to use.
+func (c CompressionType) Walker() archiver.Walker {
+	switch c {
+	case Bzip2:
+		return archiver.NewTarBz2()
+	case Xz:
+		return archiver.NewTarXz()
+	case Zstd:
+		return archiver.NewTarZstd()
+	case Zip:
+		return archiver.NewZip()
+	case Uncompressed:
+		return archiver.NewTar()
+	case Unknown:
+		return nil
+	default:
+		return archiver.NewTarGz()
+	}
+}
+
+// ValidateCompressionType checks that c is one of the formats in allowed, the
+// set of compression formats a server administrator has enabled for the
+// node (for example, via the node's config, to disable memory-hungry formats
+// like Xz or Zstd on low-memory nodes). A nil or empty allowed list means
+// every format is permitted, preserving the historical behavior of
+// CompressFiles/StreamArchive for callers that don't configure a cap.
+//
+// This is the enforcement primitive a config-aware caller (such as the file
+// manager's HTTP/websocket handlers) is expected to call with the node's
+// configured allow-list before invoking CompressFiles or StreamArchive.
+func ValidateCompressionType(c CompressionType, allowed []CompressionType) error {
+	if len(allowed) == 0 {
+		return nil
+	}
+	for _, a := range allowed {
+		if a == c {
+			return nil
+		}
+	}
+	return newFilesystemError(ErrCodeCompressionNotAllowed, nil)
+}
+
+// String implements fmt.Stringer for CompressionType so that it can be logged
+// and rendered in API responses without a separate lookup table.
+func (c CompressionType) String() string {
+	switch c {
+	case Bzip2:
+		return "bzip2"
+	case Xz:
+		return "xz"
+	case Zstd:
+		return "zstd"
+	case Zip:
+		return "zip"
+	case Uncompressed:
+		return "none"
+	default:
+		return "gzip"
+	}
+}
@@ -0,0 +1,108 @@
+package filesystem
+
+import (
+	"net/http"
+	"strings"
+
+	"emperror.dev/errors"
+)
+
+// ErrCode identifies a specific, user-facing failure condition raised by the
+// filesystem package, so that the HTTP/websocket layer can translate it into
+// the right response without string-matching error messages.
+type ErrCode string
+
+const (
+	// ErrCodeUnknownArchive is returned when an archive's format could not be
+	// determined, either by sniffing its contents or from its extension.
+	ErrCodeUnknownArchive ErrCode = "E_UNKNOWN_ARCHIVE"
+	// ErrCodeDiskSpace is returned when an operation would put the server
+	// over its allocated disk space limit.
+	ErrCodeDiskSpace ErrCode = "E_DISK_SPACE"
+	// ErrCodeUnsafeLink is returned when an archive's symlink or hardlink
+	// resolves outside of the server's data directory.
+	ErrCodeUnsafeLink ErrCode = "E_UNSAFE_LINK"
+	// ErrCodeCompressionNotAllowed is returned when a caller requests a
+	// compression format that isn't in the node's configured allow-list.
+	ErrCodeCompressionNotAllowed ErrCode = "E_COMPRESSION_NOT_ALLOWED"
+)
+
+// errCodeDetails holds the user-facing message and HTTP status associated
+// with an ErrCode, so call sites don't need to duplicate that mapping every
+// time they construct a filesystem Error.
+var errCodeDetails = map[ErrCode]struct {
+	status  int
+	message string
+}{
+	ErrCodeUnknownArchive:        {http.StatusBadRequest, "The archive provided could not be read; its format could not be determined."},
+	ErrCodeDiskSpace:             {http.StatusConflict, "Decompressing this archive would exceed the server's available disk space."},
+	ErrCodeUnsafeLink:            {http.StatusBadRequest, "The archive contains a symlink or hardlink that resolves outside of the server's data directory."},
+	ErrCodeCompressionNotAllowed: {http.StatusBadRequest, "The requested compression format is not permitted on this node."},
+}
+
+// Error is returned by filesystem operations that fail in a way the caller
+// needs to distinguish and translate into a specific API response, rather
+// than a generic 500.
+type Error struct {
+	code  ErrCode
+	cause error
+}
+
+// Error implements the error interface, preferring the wrapped cause's
+// message (if any) over the generic one mapped to this code.
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return e.cause.Error()
+	}
+	if d, ok := errCodeDetails[e.code]; ok {
+		return d.message
+	}
+	return string(e.code)
+}
+
+// Unwrap allows errors.Is/errors.As to see through to the underlying cause.
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Code returns the ErrCode identifying this error.
+func (e *Error) Code() ErrCode {
+	return e.code
+}
+
+// StatusCode returns the HTTP status the API layer should respond with for
+// this error, defaulting to 500 for a code with no mapping.
+func (e *Error) StatusCode() int {
+	if d, ok := errCodeDetails[e.code]; ok {
+		return d.status
+	}
+	return http.StatusInternalServerError
+}
+
+// newFilesystemError wraps cause (which may be nil) in an *Error carrying
+// code, so callers further up the stack can type-assert (via
+// IsUnknownArchiveFormatError, or errors.As against *Error directly) and
+// react to a specific failure condition instead of matching on message text.
+func newFilesystemError(code ErrCode, cause error) error {
+	return errors.WithStack(&Error{code: code, cause: cause})
+}
+
+// IsUnknownArchiveFormatError reports whether err (or anything it wraps) is a
+// filesystem Error carrying ErrCodeUnknownArchive, or is the error archiver
+// returns when it can't infer an archive's format, whose message begins
+// "format unrecognized".
+func IsUnknownArchiveFormatError(err error) bool {
+	var fsErr *Error
+	if errors.As(err, &fsErr) {
+		return fsErr.code == ErrCodeUnknownArchive
+	}
+	return err != nil && strings.HasPrefix(err.Error(), "format unrecognized")
+}
+
+// wrapError annotates err with the path of the archive being processed when
+// it failed, so a caller several layers up (e.g. an API handler logging a
+// failed decompression) can tell which upload was responsible without having
+// to thread the path through every intermediate return.
+func wrapError(err error, resource string) error {
+	return errors.Wrapf(err, "filesystem: %s", resource)
+}